@@ -1,33 +1,61 @@
 package main
 
 import (
+	"archive/zip"
 	"bufio"
 	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"flag"
 	"fmt"
+	"hash"
+	"io"
 	"log"
+	"net"
 	"os"
 	"os/exec"
+	"os/signal"
 	"os/user"
+	"path"
 	"path/filepath"
+	"regexp"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"gocloud.dev/blob"
+	_ "gocloud.dev/blob/azureblob"
+	_ "gocloud.dev/blob/gcsblob"
+	_ "gocloud.dev/blob/s3blob"
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/pelletier/go-toml/v2"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/sync/errgroup"
 )
 
 // --- Configuration Structures ---
 
 type Config struct {
 	Extensions []string `toml:"extensions"`
+	// HashAlgorithm selects the content hash used to identify files.
+	// Supported values are "md5" (default) and "sha256".
+	HashAlgorithm string `toml:"hash_algorithm"`
 }
 
 func getDefaultConfig() Config {
 	return Config{
-		Extensions: []string{".jpg", ".JPG", ".ARW", ".mp4", ".MP4"},
+		Extensions:    []string{".jpg", ".JPG", ".ARW", ".mp4", ".MP4"},
+		HashAlgorithm: HashAlgoMD5,
 	}
 }
 
@@ -41,49 +69,471 @@ func getDefaultDBPath() string {
 
 // --- Database Logic ---
 
-func initDB(dbPath string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", dbPath)
+// dbExecer is satisfied by both *sql.DB and *sql.Tx, so upsertLocal and
+// upsertRemote can run directly against a transaction during batched scans.
+type dbExecer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// dbQueryExecer extends dbExecer with QueryRowContext, so the hash cache -
+// which reads before it writes - can also run against a transaction instead
+// of only a bare *sql.DB. With db.SetMaxOpenConns(1) (see initDB), a
+// long-lived tx holds the pool's only connection, so anything that needs to
+// touch the database concurrently must run through that same tx rather than
+// db directly, or it blocks forever waiting for a connection that only the
+// tx's own commit would free.
+type dbQueryExecer interface {
+	dbExecer
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func initDB(ctx context.Context, dbPath string) (*sql.DB, error) {
+	// SQLite only allows one writer at a time. _busy_timeout lets a second
+	// writer retry instead of failing immediately with "database is
+	// locked", and capping the pool to a single connection means the
+	// process itself never opens a second connection that could hit that
+	// limit in the first place - important since scanLocalParallel hashes
+	// files across many goroutines that all share this one *sql.DB.
+	db, err := sql.Open("sqlite3", dbPath+"?_busy_timeout=5000")
 	if err != nil {
 		return nil, err
 	}
+	db.SetMaxOpenConns(1)
 
-	// Create table if it doesn't exist
-	// We use filename + size as the unique identifier
+	// The content hash is the primary key so the same file content is a
+	// single row regardless of what it happens to be named on either side.
+	// (filename, size) stays indexed since that's still how we pair up
+	// candidate local/remote rows (see report's -corrupt mode).
 	query := `
 	CREATE TABLE IF NOT EXISTS photos (
+		hash TEXT PRIMARY KEY,
 		filename TEXT,
 		size INTEGER,
 		local_path TEXT,
-		remote_path TEXT,
-		PRIMARY KEY (filename, size)
-	);`
+		remote_path TEXT
+	);
+	CREATE INDEX IF NOT EXISTS idx_photos_filename_size ON photos (filename, size);`
 
-	_, err = db.Exec(query)
-	if err != nil {
+	if _, err = db.ExecContext(ctx, query); err != nil {
+		return nil, err
+	}
+
+	if err := ensureHashCacheTable(ctx, db); err != nil {
 		return nil, err
 	}
 
 	return db, nil
 }
 
-func upsertLocal(db *sql.DB, filename string, size int64, path string) error {
+func upsertLocal(ctx context.Context, db dbExecer, fileHash string, filename string, size int64, path string) error {
 	query := `
-	INSERT INTO photos (filename, size, local_path) VALUES (?, ?, ?)
-	ON CONFLICT(filename, size) DO UPDATE SET local_path=excluded.local_path;
+	INSERT INTO photos (hash, filename, size, local_path) VALUES (?, ?, ?, ?)
+	ON CONFLICT(hash) DO UPDATE SET local_path=excluded.local_path;
 	`
-	_, err := db.Exec(query, filename, size, path)
+	_, err := db.ExecContext(ctx, query, fileHash, filename, size, path)
 	return err
 }
 
-func upsertRemote(db *sql.DB, filename string, size int64, path string) error {
+func upsertRemote(ctx context.Context, db dbExecer, fileHash string, filename string, size int64, path string) error {
 	query := `
-	INSERT INTO photos (filename, size, remote_path) VALUES (?, ?, ?)
-	ON CONFLICT(filename, size) DO UPDATE SET remote_path=excluded.remote_path;
+	INSERT INTO photos (hash, filename, size, remote_path) VALUES (?, ?, ?, ?)
+	ON CONFLICT(hash) DO UPDATE SET remote_path=excluded.remote_path;
 	`
-	_, err := db.Exec(query, filename, size, path)
+	_, err := db.ExecContext(ctx, query, fileHash, filename, size, path)
 	return err
 }
 
+// --- Content Hashing ---
+
+const (
+	HashAlgoMD5    = "md5"
+	HashAlgoSHA256 = "sha256"
+)
+
+// newHasher returns the hash.Hash for algo, defaulting to MD5 for anything
+// unrecognized.
+func newHasher(algo string) hash.Hash {
+	if algo == HashAlgoSHA256 {
+		return sha256.New()
+	}
+	return md5.New()
+}
+
+// hashFile streams path through algo's hasher and returns the hex digest.
+func hashFile(path string, algo string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := newHasher(algo)
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashSumCommand returns the remote shell command used to hash a file with
+// the given algorithm, e.g. "md5sum" or "sha256sum".
+func hashSumCommand(algo string) string {
+	if algo == HashAlgoSHA256 {
+		return "sha256sum"
+	}
+	return "md5sum"
+}
+
+// ensureHashCacheTable creates the cache that lets rescans skip rehashing
+// files whose (path, size, mtime, algo) haven't changed since they were
+// last hashed. algo is part of the key so switching -hash-algo doesn't
+// hand back a stale digest computed under the old algorithm.
+func ensureHashCacheTable(ctx context.Context, db *sql.DB) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS file_hash_cache (
+		path TEXT,
+		size INTEGER,
+		mtime INTEGER,
+		algo TEXT,
+		hash TEXT,
+		PRIMARY KEY (path, size, mtime, algo)
+	);`
+	_, err := db.ExecContext(ctx, query)
+	return err
+}
+
+func getCachedHash(ctx context.Context, db dbQueryExecer, path string, size int64, mtime int64, algo string) (string, bool, error) {
+	var h string
+	err := db.QueryRowContext(ctx,
+		`SELECT hash FROM file_hash_cache WHERE path = ? AND size = ? AND mtime = ? AND algo = ?`,
+		path, size, mtime, algo,
+	).Scan(&h)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return h, true, nil
+}
+
+func setCachedHash(ctx context.Context, db dbExecer, path string, size int64, mtime int64, algo string, fileHash string) error {
+	query := `
+	INSERT INTO file_hash_cache (path, size, mtime, algo, hash) VALUES (?, ?, ?, ?, ?)
+	ON CONFLICT(path, size, mtime, algo) DO UPDATE SET hash=excluded.hash;
+	`
+	_, err := db.ExecContext(ctx, query, path, size, mtime, algo, fileHash)
+	return err
+}
+
+// --- Remote Transport ---
+
+// Transporter abstracts the remote side of a sync so runSync can treat a
+// live SSH target and a dry-run preview identically.
+type Transporter interface {
+	// Upload copies localPath to remotePath on the target, creating any
+	// missing parent directories and verifying the transfer size.
+	Upload(localPath, remotePath string) error
+	// Download copies remotePath on the target to localPath.
+	Download(remotePath, localPath string) error
+	// Run executes cmd on the target and returns its output.
+	Run(cmd string) (string, error)
+}
+
+// SFTPTransporter moves files over SFTP on top of an SSH connection
+// authenticated the same way the `ssh` CLI is (agent + known_hosts), so it
+// drops in wherever a shelled-out ssh/scp command used to run.
+type SFTPTransporter struct {
+	sshCli *ssh.Client
+	sftp   *sftp.Client
+}
+
+// NewSFTPTransporter dials remote (e.g. "user@192.168.1.100") over SSH and
+// opens an SFTP session on top of it.
+func NewSFTPTransporter(remote string) (*SFTPTransporter, error) {
+	user, addr := splitRemote(remote)
+
+	auth, err := sshAgentAuth()
+	if err != nil {
+		return nil, fmt.Errorf("ssh agent: %w", err)
+	}
+
+	hostKeyCallback, err := knownHostsCallback()
+	if err != nil {
+		return nil, fmt.Errorf("known_hosts: %w", err)
+	}
+
+	sshCli, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{auth},
+		HostKeyCallback: hostKeyCallback,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("dial %s: %w", addr, err)
+	}
+
+	sftpCli, err := sftp.NewClient(sshCli)
+	if err != nil {
+		sshCli.Close()
+		return nil, fmt.Errorf("open sftp session: %w", err)
+	}
+
+	return &SFTPTransporter{sshCli: sshCli, sftp: sftpCli}, nil
+}
+
+func (t *SFTPTransporter) Close() error {
+	t.sftp.Close()
+	return t.sshCli.Close()
+}
+
+func (t *SFTPTransporter) Upload(localPath, remotePath string) error {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return err
+	}
+
+	if err := t.sftp.MkdirAll(path.Dir(remotePath)); err != nil {
+		return fmt.Errorf("mkdir %s: %w", path.Dir(remotePath), err)
+	}
+
+	src, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := t.sftp.Create(remotePath)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", remotePath, err)
+	}
+	defer dst.Close()
+
+	if _, err := io.Copy(dst, src); err != nil {
+		return fmt.Errorf("copy to %s: %w", remotePath, err)
+	}
+
+	remoteInfo, err := t.sftp.Stat(remotePath)
+	if err != nil {
+		return fmt.Errorf("stat %s after upload: %w", remotePath, err)
+	}
+	if remoteInfo.Size() != info.Size() {
+		return fmt.Errorf("size mismatch after upload: local %d bytes, remote %s has %d bytes", info.Size(), remotePath, remoteInfo.Size())
+	}
+
+	return nil
+}
+
+func (t *SFTPTransporter) Download(remotePath, localPath string) error {
+	src, err := t.sftp.Open(remotePath)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", remotePath, err)
+	}
+	defer src.Close()
+
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return err
+	}
+
+	dst, err := os.Create(localPath)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	if _, err := io.Copy(dst, src); err != nil {
+		return fmt.Errorf("copy from %s: %w", remotePath, err)
+	}
+	return nil
+}
+
+func (t *SFTPTransporter) Run(cmd string) (string, error) {
+	session, err := t.sshCli.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+
+	out, err := session.CombinedOutput(cmd)
+	return string(out), err
+}
+
+// isBucketRemote reports whether remote is a gocloud.dev bucket URL
+// (s3://, gs://, or azblob://) rather than an SSH target.
+func isBucketRemote(remote string) bool {
+	for _, scheme := range []string{"s3://", "gs://", "azblob://"} {
+		if strings.HasPrefix(remote, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// BucketTransporter uploads and downloads against a gocloud.dev bucket, so
+// the same sync flow that drives SFTP also drives S3/GCS/Azure Blob
+// targets - the two are just different implementations of "object lister /
+// uploader".
+type BucketTransporter struct {
+	bucket *blob.Bucket
+}
+
+func NewBucketTransporter(bucketURL string) (*BucketTransporter, error) {
+	bucket, err := blob.OpenBucket(context.Background(), bucketURL)
+	if err != nil {
+		return nil, err
+	}
+	return &BucketTransporter{bucket: bucket}, nil
+}
+
+func (t *BucketTransporter) Close() error {
+	return t.bucket.Close()
+}
+
+func (t *BucketTransporter) Upload(localPath, remotePath string) error {
+	ctx := context.Background()
+
+	src, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	w, err := t.bucket.NewWriter(ctx, remotePath, nil)
+	if err != nil {
+		return fmt.Errorf("open writer for %s: %w", remotePath, err)
+	}
+
+	if _, err := io.Copy(w, src); err != nil {
+		w.Close()
+		return fmt.Errorf("copy to %s: %w", remotePath, err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("close writer for %s: %w", remotePath, err)
+	}
+
+	attrs, err := t.bucket.Attributes(ctx, remotePath)
+	if err != nil {
+		return fmt.Errorf("attributes for %s: %w", remotePath, err)
+	}
+	if attrs.Size != info.Size() {
+		return fmt.Errorf("size mismatch after upload: local %d bytes, bucket object %s has %d bytes", info.Size(), remotePath, attrs.Size)
+	}
+	return nil
+}
+
+func (t *BucketTransporter) Download(remotePath, localPath string) error {
+	ctx := context.Background()
+
+	r, err := t.bucket.NewReader(ctx, remotePath, nil)
+	if err != nil {
+		return fmt.Errorf("open reader for %s: %w", remotePath, err)
+	}
+	defer r.Close()
+
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return err
+	}
+
+	dst, err := os.Create(localPath)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	if _, err := io.Copy(dst, r); err != nil {
+		return fmt.Errorf("copy from %s: %w", remotePath, err)
+	}
+	return nil
+}
+
+func (t *BucketTransporter) Run(cmd string) (string, error) {
+	return "", fmt.Errorf("Run is not supported for bucket transports")
+}
+
+// DryTransporter only logs what a sync would do; it never opens an SSH
+// session, so `-dry-run` works without network access or valid credentials.
+type DryTransporter struct{}
+
+func (t *DryTransporter) Upload(localPath, remotePath string) error {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s -> %s (%d bytes)\n", localPath, remotePath, info.Size())
+	return nil
+}
+
+func (t *DryTransporter) Download(remotePath, localPath string) error {
+	fmt.Printf("%s -> %s\n", remotePath, localPath)
+	return nil
+}
+
+func (t *DryTransporter) Run(cmd string) (string, error) {
+	fmt.Printf("[dry-run] would run: %s\n", cmd)
+	return "", nil
+}
+
+// splitRemote splits a "user@host" remote spec into its user and addr
+// (host:port, defaulting to port 22) parts. If no user is given, it falls
+// back to the current OS user, matching ssh's own behavior.
+func splitRemote(remote string) (user, addr string) {
+	userPart := ""
+	hostPart := remote
+	if idx := strings.Index(remote, "@"); idx != -1 {
+		userPart = remote[:idx]
+		hostPart = remote[idx+1:]
+	}
+	if userPart == "" {
+		if u, err := osUserCurrent(); err == nil {
+			userPart = u
+		}
+	}
+	if !strings.Contains(hostPart, ":") {
+		hostPart = hostPart + ":22"
+	}
+	return userPart, hostPart
+}
+
+// osUserCurrent is a small indirection over os/user so splitRemote has a
+// single fallible call site.
+func osUserCurrent() (string, error) {
+	u, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+	return u.Username, nil
+}
+
+// sshAgentAuth authenticates via the running ssh-agent, the same mechanism
+// the `ssh` CLI uses by default.
+func sshAgentAuth() (ssh.AuthMethod, error) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, fmt.Errorf("SSH_AUTH_SOCK is not set; is ssh-agent running?")
+	}
+
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, fmt.Errorf("connect to ssh-agent: %w", err)
+	}
+
+	agentClient := agent.NewClient(conn)
+	return ssh.PublicKeysCallback(agentClient.Signers), nil
+}
+
+// knownHostsCallback verifies remote host keys against ~/.ssh/known_hosts,
+// same as the `ssh` CLI.
+func knownHostsCallback() (ssh.HostKeyCallback, error) {
+	usr, err := user.Current()
+	if err != nil {
+		return nil, err
+	}
+	return knownhosts.New(filepath.Join(usr.HomeDir, ".ssh", "known_hosts"))
+}
+
 // --- Configuration Management ---
 
 func loadOrCreateConfig() (*Config, error) {
@@ -140,12 +590,14 @@ func isExtensionAllowed(path string, extensions []string) bool {
 	return false
 }
 
-func runAdd(args []string, cfg *Config) {
+func runAdd(ctx context.Context, args []string, cfg *Config) {
 	defaultDB := getDefaultDBPath()
 	addCmd := flag.NewFlagSet("add", flag.ExitOnError)
 	remotePtr := addCmd.String("remote", "", "The remote server address (e.g. user@192.168.1.100). If empty, scans local.")
 	pathPtr := addCmd.String("path", "", "The directory path to scan")
 	dbPtr := addCmd.String("db", defaultDB, "Path to the sqlite database file")
+	hashAlgoPtr := addCmd.String("hash-algo", "", "Content hash algorithm: md5 or sha256 (defaults to config's hash_algorithm)")
+	parallelPtr := addCmd.Int("parallel", runtime.NumCPU(), "Number of concurrent workers for local scanning")
 
 	addCmd.Parse(args)
 
@@ -155,20 +607,40 @@ func runAdd(args []string, cfg *Config) {
 		os.Exit(1)
 	}
 
-	db, err := initDB(*dbPtr)
+	algo := cfg.HashAlgorithm
+	if *hashAlgoPtr != "" {
+		algo = *hashAlgoPtr
+	}
+	if algo == "" {
+		algo = HashAlgoMD5
+	}
+
+	db, err := initDB(ctx, *dbPtr)
 	if err != nil {
 		log.Fatalf("Failed to initialize DB: %v", err)
 	}
 	defer db.Close()
 
+	// --- Remote Scan (cloud bucket) ---
+	if isBucketRemote(*remotePtr) {
+		scanBucket(ctx, db, cfg, *remotePtr, *pathPtr, algo)
+		return
+	}
+
 	// --- Remote Scan (via SSH) ---
 	if *remotePtr != "" {
 		fmt.Printf("Scanning REMOTE [%s] at path [%s]...\n", *remotePtr, *pathPtr)
-		
-		// We construct a find command to run over SSH.
-		// We use -printf to get filename, size, and full path separated by tabs.
-		sshCmd := exec.Command("ssh", *remotePtr, "find", *pathPtr, "-type", "f", "-printf", "'%f\t%s\t%p\n'")
-		
+
+		// We construct a find+hash pipeline to run over SSH: find locates
+		// candidate files, then each one is piped through md5sum/sha256sum
+		// so the remote hash comes back in the same pass instead of a
+		// separate round-trip.
+		remoteScript := fmt.Sprintf(
+			`find %s -type f -printf '%%f\t%%s\t%%p\n' | while IFS=$'\t' read -r name size fpath; do hash=$(%s "$fpath" | cut -d' ' -f1); printf '%%s\t%%s\t%%s\t%%s\n' "$name" "$size" "$fpath" "$hash"; done`,
+			shellQuote(*pathPtr), hashSumCommand(algo),
+		)
+		sshCmd := exec.CommandContext(ctx, "ssh", *remotePtr, remoteScript)
+
 		// Capture output
 		var out bytes.Buffer
 		var stderr bytes.Buffer
@@ -187,7 +659,7 @@ func runAdd(args []string, cfg *Config) {
 		for scanner.Scan() {
 			line := scanner.Text()
 			parts := strings.Split(line, "\t")
-			if len(parts) != 3 {
+			if len(parts) != 4 {
 				continue
 			}
 			name := parts[0]
@@ -196,12 +668,13 @@ func runAdd(args []string, cfg *Config) {
 				continue
 			}
 			fullPath := parts[2]
+			fileHash := parts[3]
 
 			if !isExtensionAllowed(name, cfg.Extensions) {
 				continue
 			}
 
-			if err := upsertRemote(db, name, size, fullPath); err != nil {
+			if err := upsertRemote(ctx, tx, fileHash, name, size, fullPath); err != nil {
 				log.Printf("Error inserting remote file %s: %v", name, err)
 			}
 			count++
@@ -213,63 +686,714 @@ func runAdd(args []string, cfg *Config) {
 		fmt.Printf("\nComplete. Processed %d matching remote files.\n", count)
 
 	} else {
-		// --- Local Scan ---
+		// --- Local Scan (parallel) ---
 		fmt.Printf("Scanning LOCAL path [%s]...\n", *pathPtr)
 
-		count := 0
-		err := filepath.WalkDir(*pathPtr, func(path string, d os.DirEntry, err error) error {
+		parallel := *parallelPtr
+		if parallel < 1 {
+			parallel = 1
+		}
+
+		count, err := scanLocalParallel(ctx, db, *pathPtr, cfg.Extensions, algo, parallel)
+		if err != nil {
+			log.Fatalf("Error walking local path: %v", err)
+		}
+		fmt.Printf("\nComplete. Processed %d matching local files.\n", count)
+	}
+}
+
+// walkEntry is one file discovered by the WalkDir goroutine in
+// scanLocalParallel, handed off to a worker for stat/hash/upsert.
+type walkEntry struct {
+	path string
+	d    os.DirEntry
+}
+
+// scanLocalParallel walks root and fans the matching files out to parallel
+// worker goroutines that hash and upsert each one under a single shared
+// transaction, committing every 1000 rows so memory stays bounded on very
+// large libraries. Any worker error (including ctx cancellation) stops the
+// walk via the errgroup's shared context.
+//
+// db.SetMaxOpenConns(1) means tx holds the only connection for its whole
+// lifetime, so every database access here - including the hash cache -
+// must go through tx (guarded by mu, since *sql.Tx isn't safe for
+// concurrent use) rather than db directly. Only the hash computation
+// itself, which touches no database, runs unlocked across workers.
+func scanLocalParallel(ctx context.Context, db *sql.DB, root string, extensions []string, algo string, parallel int) (int64, error) {
+	const commitEvery = 1000
+
+	g, gctx := errgroup.WithContext(ctx)
+	entries := make(chan walkEntry, 256)
+
+	// tx is bound to ctx, not gctx: errgroup cancels gctx as soon as
+	// Wait returns even on success, and database/sql auto-rolls-back a
+	// tx as soon as the context it was opened with is canceled - binding
+	// to gctx would roll the transaction back out from under the final
+	// Commit below on every successful scan.
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		mu    sync.Mutex
+		count int64
+	)
+
+	for i := 0; i < parallel; i++ {
+		g.Go(func() error {
+			for entry := range entries {
+				info, err := entry.d.Info()
+				if err != nil {
+					return err
+				}
+				absPath, err := filepath.Abs(entry.path)
+				if err != nil {
+					return err
+				}
+				mtime := info.ModTime().Unix()
+
+				mu.Lock()
+				cached, ok, err := getCachedHash(gctx, tx, absPath, info.Size(), mtime, algo)
+				mu.Unlock()
+				if err != nil {
+					return err
+				}
+
+				fileHash := cached
+				if !ok {
+					fileHash, err = hashFile(absPath, algo)
+					if err != nil {
+						return err
+					}
+				}
+
+				mu.Lock()
+				if !ok {
+					err = setCachedHash(gctx, tx, absPath, info.Size(), mtime, algo, fileHash)
+				}
+				if err == nil {
+					err = upsertLocal(gctx, tx, fileHash, entry.d.Name(), info.Size(), absPath)
+				}
+				var n int64
+				if err == nil {
+					count++
+					n = count
+					if n%commitEvery == 0 {
+						if cerr := tx.Commit(); cerr != nil {
+							err = cerr
+						} else {
+							tx, err = db.BeginTx(ctx, nil)
+						}
+					}
+				}
+				mu.Unlock()
+
+				if err != nil {
+					return err
+				}
+				if n%100 == 0 {
+					fmt.Printf("\rProcessed %d local files...", n)
+				}
+			}
+			return nil
+		})
+	}
+
+	g.Go(func() error {
+		defer close(entries)
+		return filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
 			if err != nil {
 				return err
 			}
 			if d.IsDir() {
 				return nil
 			}
-
-			if !isExtensionAllowed(path, cfg.Extensions) {
+			if !isExtensionAllowed(path, extensions) {
 				return nil
 			}
+			select {
+			case <-gctx.Done():
+				return gctx.Err()
+			case entries <- walkEntry{path: path, d: d}:
+				return nil
+			}
+		})
+	})
+
+	if err := g.Wait(); err != nil {
+		tx.Rollback()
+		return count, err
+	}
+	if err := tx.Commit(); err != nil {
+		return count, err
+	}
+	return count, nil
+}
 
-			info, err := d.Info()
+// shellQuote wraps s in single quotes for safe embedding in a remote shell
+// command, escaping any single quotes already in s.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// hashBucketObject streams key's content through algo's hasher. It's used
+// whenever the bucket's own ListObject.MD5 can't stand in for the real
+// content hash - either because algo isn't MD5, or because the provider
+// didn't return one at all (S3 omits it for multipart-uploaded objects,
+// which is the common case for this tool's large .mp4/.ARW files).
+func hashBucketObject(ctx context.Context, bucket *blob.Bucket, key string, algo string) (string, error) {
+	r, err := bucket.NewReader(ctx, key, nil)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	h := newHasher(algo)
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// scanBucket lists a gocloud.dev bucket (s3://, gs://, azblob://) under
+// prefix and records each object as a remote file, the bucket equivalent of
+// the SSH find scan above.
+func scanBucket(ctx context.Context, db *sql.DB, cfg *Config, bucketURL string, prefix string, algo string) {
+	fmt.Printf("Scanning BUCKET [%s] with prefix [%s]...\n", bucketURL, prefix)
+
+	bucket, err := blob.OpenBucket(ctx, bucketURL)
+	if err != nil {
+		log.Fatalf("Failed to open bucket %s: %v", bucketURL, err)
+	}
+	defer bucket.Close()
+
+	iter := bucket.List(&blob.ListOptions{Prefix: prefix})
+	count := 0
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatalf("Error listing bucket %s: %v", bucketURL, err)
+		}
+
+		name := path.Base(obj.Key)
+		if !isExtensionAllowed(name, cfg.Extensions) {
+			continue
+		}
+
+		// obj.MD5 only matches a local hash when we're hashing locally with
+		// MD5 too; for any other configured algorithm (or when MD5 is
+		// missing) we stream the object through the same hasher local scans
+		// use, so the two sides can actually join on hash.
+		var fileHash string
+		if algo == HashAlgoMD5 && len(obj.MD5) > 0 {
+			fileHash = hex.EncodeToString(obj.MD5)
+		} else {
+			fileHash, err = hashBucketObject(ctx, bucket, obj.Key, algo)
 			if err != nil {
-				return err
+				log.Printf("Error hashing bucket object %s: %v", obj.Key, err)
+				continue
 			}
-			absPath, _ := filepath.Abs(path)
+		}
 
-			if err := upsertLocal(db, d.Name(), info.Size(), absPath); err != nil {
-				return err
+		if err := upsertRemote(ctx, db, fileHash, name, obj.Size, obj.Key); err != nil {
+			log.Printf("Error inserting bucket object %s: %v", obj.Key, err)
+		}
+		count++
+		if count%100 == 0 {
+			fmt.Printf("\rProcessed %d bucket objects...", count)
+		}
+	}
+	fmt.Printf("\nComplete. Processed %d matching bucket objects.\n", count)
+}
+
+// remoteSyncPath mirrors localPath's directory structure under remoteRoot,
+// so files that share a basename - extremely common for camera-generated
+// names like DSC_0001.jpg coming from different imports or cards - don't
+// overwrite each other the way joining remoteRoot with the bare filename
+// would.
+func remoteSyncPath(remoteRoot string, localPath string) string {
+	rel := filepath.ToSlash(strings.TrimPrefix(localPath, string(filepath.Separator)))
+	return path.Join(remoteRoot, rel)
+}
+
+func runSync(ctx context.Context, args []string) {
+	defaultDB := getDefaultDBPath()
+	syncCmd := flag.NewFlagSet("sync", flag.ExitOnError)
+	remotePtr := syncCmd.String("remote", "", "The remote server address (e.g. user@192.168.1.100)")
+	remoteRootPtr := syncCmd.String("remote-root", "", "Remote directory uploaded files are placed under")
+	dbPtr := syncCmd.String("db", defaultDB, "Path to the sqlite database file")
+	dryRunPtr := syncCmd.Bool("dry-run", false, "Print planned transfers without opening an SSH session")
+	parallelPtr := syncCmd.Int("parallel", 4, "Number of concurrent uploads")
+	limitBytesPtr := syncCmd.Int64("limit-bytes", 0, "Stop scheduling new uploads after this many bytes (0 = unlimited)")
+
+	syncCmd.Parse(args)
+
+	if *remotePtr == "" {
+		fmt.Println("Error: -remote is required")
+		syncCmd.PrintDefaults()
+		os.Exit(1)
+	}
+
+	db, err := initDB(ctx, *dbPtr)
+	if err != nil {
+		log.Fatalf("Failed to open DB: %v", err)
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT hash, filename, local_path FROM photos
+		WHERE local_path IS NOT NULL AND local_path != ''
+		  AND (remote_path IS NULL OR remote_path = '')
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	type pendingUpload struct {
+		hash, filename, localPath string
+	}
+	var pending []pendingUpload
+	for rows.Next() {
+		var p pendingUpload
+		if err := rows.Scan(&p.hash, &p.filename, &p.localPath); err != nil {
+			log.Fatal(err)
+		}
+		pending = append(pending, p)
+	}
+	rows.Close()
+
+	var transporter Transporter
+	switch {
+	case *dryRunPtr:
+		transporter = &DryTransporter{}
+	case isBucketRemote(*remotePtr):
+		bucketT, err := NewBucketTransporter(*remotePtr)
+		if err != nil {
+			log.Fatalf("Failed to open bucket %s: %v", *remotePtr, err)
+		}
+		defer bucketT.Close()
+		transporter = bucketT
+	default:
+		sftpT, err := NewSFTPTransporter(*remotePtr)
+		if err != nil {
+			log.Fatalf("Failed to connect to %s: %v", *remotePtr, err)
+		}
+		defer sftpT.Close()
+		transporter = sftpT
+	}
+
+	parallel := *parallelPtr
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	var (
+		mu         sync.Mutex
+		totalBytes int64
+		planned    int
+		limitHit   bool
+		wg         sync.WaitGroup
+		sem        = make(chan struct{}, parallel)
+	)
+
+	for _, item := range pending {
+		mu.Lock()
+		hit := *limitBytesPtr > 0 && totalBytes >= *limitBytesPtr
+		mu.Unlock()
+		if hit {
+			limitHit = true
+			break
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(it pendingUpload) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			info, err := os.Stat(it.localPath)
+			if err != nil {
+				log.Printf("Stat %s: %v", it.localPath, err)
+				return
 			}
-			
-			count++
-			if count%100 == 0 {
-				fmt.Printf("\rProcessed %d local files...", count)
+
+			remotePath := remoteSyncPath(*remoteRootPtr, it.localPath)
+
+			if err := transporter.Upload(it.localPath, remotePath); err != nil {
+				log.Printf("Upload %s: %v", it.localPath, err)
+				return
 			}
-			return nil
-		})
 
-		if err != nil {
-			log.Fatalf("Error walking local path: %v", err)
+			mu.Lock()
+			totalBytes += info.Size()
+			planned++
+			mu.Unlock()
+
+			if *dryRunPtr {
+				return
+			}
+
+			if err := upsertRemote(ctx, db, it.hash, it.filename, info.Size(), remotePath); err != nil {
+				log.Printf("Recording %s: %v", it.localPath, err)
+			}
+		}(item)
+	}
+	wg.Wait()
+
+	if *dryRunPtr {
+		fmt.Printf("Dry run: %d file(s), %d bytes planned\n", planned, totalBytes)
+		return
+	}
+	if limitHit {
+		fmt.Printf("Stopped after -limit-bytes reached. ")
+	}
+	fmt.Printf("Synced %d bytes to %s\n", totalBytes, *remotePtr)
+}
+
+// --- Backup (offline zip) ---
+
+const (
+	backupFilePrefix = "myphotos-backup-"
+	backupFileSuffix = ".zip"
+	backupTimeLayout = "2006-01-02-15-04-05"
+)
+
+var backupFilenamePattern = regexp.MustCompile(
+	`^` + regexp.QuoteMeta(backupFilePrefix) + `(\d{4}-\d{2}-\d{2}-\d{2}-\d{2}-\d{2})(?:\.(\d+))?` + regexp.QuoteMeta(backupFileSuffix) + `$`,
+)
+
+// backupVolumeName returns the filename for the given 1-indexed volume of a
+// backup run started at ts. Volume 1 has no suffix; later volumes (from
+// -split rotation) are numbered so they sort together with their siblings.
+func backupVolumeName(ts time.Time, volume int) string {
+	if volume <= 1 {
+		return backupFilePrefix + ts.Format(backupTimeLayout) + backupFileSuffix
+	}
+	return fmt.Sprintf("%s%s.%d%s", backupFilePrefix, ts.Format(backupTimeLayout), volume, backupFileSuffix)
+}
+
+// backupFileTimestamp parses a myphotos-backup-*.zip filename back into the
+// timestamp it was created at, rejecting anything that doesn't match the
+// prefix+datefmt+suffix pattern since -out-dir may hold unrelated files.
+func backupFileTimestamp(name string) (time.Time, bool) {
+	m := backupFilenamePattern.FindStringSubmatch(name)
+	if m == nil {
+		return time.Time{}, false
+	}
+	ts, err := time.Parse(backupTimeLayout, m[1])
+	if err != nil {
+		return time.Time{}, false
+	}
+	return ts, true
+}
+
+// addFileToZip adds localPath to zw under a name relative to homeDir (using
+// forward slashes), so the archive can be restored onto a differently
+// rooted disk.
+func addFileToZip(zw *zip.Writer, localPath string, homeDir string) error {
+	rel, err := filepath.Rel(homeDir, localPath)
+	if err != nil {
+		rel = filepath.Base(localPath)
+	}
+
+	src, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	w, err := zw.Create(filepath.ToSlash(rel))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, src)
+	return err
+}
+
+// zipMissingFiles writes every local-but-not-on-remote file into a single
+// zip at zipPath, the cold-backup workflow without any -split rotation.
+func zipMissingFiles(db *sql.DB, zipPath string, homeDir string) error {
+	rows, err := db.Query(`
+		SELECT local_path FROM photos
+		WHERE local_path IS NOT NULL AND local_path != ''
+		  AND (remote_path IS NULL OR remote_path = '')
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var paths []string
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			return err
+		}
+		paths = append(paths, p)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	out, err := os.Create(zipPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	zw := zip.NewWriter(out)
+	defer zw.Close()
+
+	for _, p := range paths {
+		if err := addFileToZip(zw, p, homeDir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneBackups keeps only the `keep` most recent backup runs in dir - a run
+// being every myphotos-backup-*.zip file sharing the same encoded
+// timestamp, since a -split backup's volumes are only useful together - and
+// deletes the rest. Filenames that don't match the backup pattern are left
+// alone, since the directory may hold unrelated files.
+func pruneBackups(dir string, keep int) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	type backupFile struct {
+		name string
+		ts   time.Time
+	}
+	var files []backupFile
+	var runs []time.Time
+	seenRun := make(map[time.Time]bool)
+
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		ts, ok := backupFileTimestamp(e.Name())
+		if !ok {
+			continue
+		}
+		files = append(files, backupFile{name: e.Name(), ts: ts})
+		if !seenRun[ts] {
+			seenRun[ts] = true
+			runs = append(runs, ts)
+		}
+	}
+
+	sort.Slice(runs, func(i, j int) bool { return runs[i].After(runs[j]) })
+	if keep >= len(runs) {
+		return nil
+	}
+
+	keepRun := make(map[time.Time]bool, keep)
+	for _, ts := range runs[:keep] {
+		keepRun[ts] = true
+	}
+
+	for _, f := range files {
+		if keepRun[f.ts] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, f.name)); err != nil {
+			return err
 		}
-		fmt.Printf("\nComplete. Processed %d matching local files.\n", count)
 	}
+	return nil
 }
 
-func runReport(args []string) {
+func runBackup(ctx context.Context, args []string) {
+	defaultDB := getDefaultDBPath()
+	backupCmd := flag.NewFlagSet("backup", flag.ExitOnError)
+	dbPtr := backupCmd.String("db", defaultDB, "Path to the sqlite database file")
+	outDirPtr := backupCmd.String("out-dir", ".", "Directory to write backup zip(s) into")
+	maxSizeBytesPtr := backupCmd.Int64("max-size-bytes", 0, "Maximum total uncompressed size of the files per zip volume when -split is set (0 = unlimited); a single larger file gets a volume of its own")
+	keepPtr := backupCmd.Int("keep", 0, "Number of most recent backup runs to retain in -out-dir (0 = keep all)")
+	splitPtr := backupCmd.Bool("split", false, "Rotate to a new zip volume once -max-size-bytes would be exceeded")
+
+	backupCmd.Parse(args)
+
+	db, err := initDB(ctx, *dbPtr)
+	if err != nil {
+		log.Fatalf("Failed to open DB: %v", err)
+	}
+	defer db.Close()
+
+	if err := os.MkdirAll(*outDirPtr, 0755); err != nil {
+		log.Fatalf("Failed to create -out-dir %s: %v", *outDirPtr, err)
+	}
+
+	usr, err := user.Current()
+	if err != nil {
+		log.Fatalf("Failed to resolve home directory: %v", err)
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT local_path FROM photos
+		WHERE local_path IS NOT NULL AND local_path != ''
+		  AND (remote_path IS NULL OR remote_path = '')
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var paths []string
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			log.Fatal(err)
+		}
+		paths = append(paths, p)
+	}
+	rows.Close()
+
+	ts := time.Now()
+	volume := 1
+	volumePath := filepath.Join(*outDirPtr, backupVolumeName(ts, volume))
+	out, err := os.Create(volumePath)
+	if err != nil {
+		log.Fatalf("Failed to create %s: %v", volumePath, err)
+	}
+	zw := zip.NewWriter(out)
+	var volumeBytes int64
+	volumeCount := 1
+	written := 0
+
+	closeVolume := func() {
+		if err := zw.Close(); err != nil {
+			log.Fatalf("Failed to finalize %s: %v", volumePath, err)
+		}
+		if err := out.Close(); err != nil {
+			log.Fatalf("Failed to close %s: %v", volumePath, err)
+		}
+	}
+
+	for _, p := range paths {
+		info, statErr := os.Stat(p)
+		if statErr != nil {
+			log.Printf("Skipping %s: %v", p, statErr)
+			continue
+		}
+
+		if *splitPtr && *maxSizeBytesPtr > 0 && volumeBytes > 0 && volumeBytes+info.Size() > *maxSizeBytesPtr {
+			closeVolume()
+			volume++
+			volumeCount++
+			volumePath = filepath.Join(*outDirPtr, backupVolumeName(ts, volume))
+			out, err = os.Create(volumePath)
+			if err != nil {
+				log.Fatalf("Failed to create %s: %v", volumePath, err)
+			}
+			zw = zip.NewWriter(out)
+			volumeBytes = 0
+		}
+
+		if err := addFileToZip(zw, p, usr.HomeDir); err != nil {
+			log.Fatalf("Failed to add %s to backup: %v", p, err)
+		}
+		volumeBytes += info.Size()
+		written++
+	}
+	closeVolume()
+
+	fmt.Printf("Wrote %d file(s) across %d backup volume(s) to %s\n", written, volumeCount, *outDirPtr)
+
+	if *keepPtr > 0 {
+		if err := pruneBackups(*outDirPtr, *keepPtr); err != nil {
+			log.Printf("Failed to prune old backups: %v", err)
+		}
+	}
+}
+
+func runReport(ctx context.Context, args []string) {
 	defaultDB := getDefaultDBPath()
 	reportCmd := flag.NewFlagSet("report", flag.ExitOnError)
 	dbPtr := reportCmd.String("db", defaultDB, "Path to the sqlite database file")
 	verbosePtr := reportCmd.Bool("verbose", false, "Show full list of files")
 	vPtr := reportCmd.Bool("v", false, "Show full list of files (shorthand)")
 	wrongSizePtr := reportCmd.Bool("wrong_size", false, "Report files present on remote but with different size")
+	renamedPtr := reportCmd.Bool("renamed", false, "Report files whose local and remote hashes match but whose paths' basenames differ")
+	corruptPtr := reportCmd.Bool("corrupt", false, "Report files whose name+size match but whose local and remote hashes diverge")
 	reportCmd.Parse(args)
 
 	isVerbose := *verbosePtr || *vPtr
 
-	db, err := initDB(*dbPtr)
+	db, err := initDB(ctx, *dbPtr)
 	if err != nil {
 		log.Fatalf("Failed to open DB: %v", err)
 	}
 	defer db.Close()
 
+	if *renamedPtr {
+		query := `
+			SELECT local_path, remote_path
+			FROM photos
+			WHERE local_path IS NOT NULL AND local_path != ''
+			  AND remote_path IS NOT NULL AND remote_path != ''
+		`
+		rows, err := db.QueryContext(ctx, query)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer rows.Close()
+
+		fmt.Println("--- Files Renamed (Same Content Hash, Different Filename) ---")
+		count := 0
+		for rows.Next() {
+			var lPath, rPath string
+			if err := rows.Scan(&lPath, &rPath); err != nil {
+				log.Fatal(err)
+			}
+			if filepath.Base(lPath) == filepath.Base(rPath) {
+				continue
+			}
+			fmt.Printf("Local:  %s\nRemote: %s\n\n", lPath, rPath)
+			count++
+		}
+		fmt.Printf("--------------------------------------------------------------\n")
+		fmt.Printf("Total Renamed: %d\n", count)
+		return
+	}
+
+	if *corruptPtr {
+		query := `
+			SELECT p1.local_path, p1.hash, p2.remote_path, p2.hash
+			FROM photos p1
+			JOIN photos p2 ON p1.filename = p2.filename AND p1.size = p2.size
+			WHERE p1.local_path IS NOT NULL AND p1.local_path != ''
+			  AND p2.remote_path IS NOT NULL AND p2.remote_path != ''
+			  AND p1.hash != p2.hash
+		`
+		rows, err := db.QueryContext(ctx, query)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer rows.Close()
+
+		fmt.Println("--- Files with Name+Size Match but Diverging Hash (Possible Corruption) ---")
+		count := 0
+		for rows.Next() {
+			var lPath, lHash, rPath, rHash string
+			if err := rows.Scan(&lPath, &lHash, &rPath, &rHash); err != nil {
+				log.Fatal(err)
+			}
+			fmt.Printf("Local:  %s (%s)\nRemote: %s (%s)\n\n", lPath, lHash, rPath, rHash)
+			count++
+		}
+		fmt.Printf("-----------------------------------------------------------------------------\n")
+		fmt.Printf("Total Corrupt: %d\n", count)
+		return
+	}
+
 	if *wrongSizePtr {
 		query := `
 			SELECT p1.local_path, p1.size, p2.remote_path, p2.size
@@ -278,8 +1402,9 @@ func runReport(args []string) {
 			WHERE p1.local_path IS NOT NULL AND p1.local_path != ''
 			  AND (p1.remote_path IS NULL OR p1.remote_path = '')
 			  AND p2.remote_path IS NOT NULL AND p2.remote_path != ''
+			  AND p1.size != p2.size
 		`
-		rows, err := db.Query(query)
+		rows, err := db.QueryContext(ctx, query)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -304,7 +1429,7 @@ func runReport(args []string) {
 	// Query for files that are local (backed up?) but NOT on remote.
 	// We check if local_path is set and remote_path is NULL or empty.
 	
-	rows, err := db.Query("SELECT local_path FROM photos WHERE local_path IS NOT NULL AND local_path != '' AND (remote_path IS NULL OR remote_path = '')")
+	rows, err := db.QueryContext(ctx, "SELECT local_path FROM photos WHERE local_path IS NOT NULL AND local_path != '' AND (remote_path IS NULL OR remote_path = '')")
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -352,11 +1477,19 @@ func printHelp() {
 	fmt.Println("Usage: go run myphotos.go <command> [flags]")
 	fmt.Println("\nCommands:")
 	fmt.Println("  add     Scan and add files to the database")
+	fmt.Println("  sync    Upload files missing from the remote")
+	fmt.Println("  backup  Zip files missing from the remote for offline cold storage")
 	fmt.Println("  report  Generate a report of missing files")
 	fmt.Println("  help    Show this help message")
 }
 
 func main() {
+	// Ctrl-C cleanly cancels a long scan. A local scan keeps the rows it has
+	// already committed (it commits every 1000 rows); the rows of the batch
+	// not yet committed are rolled back.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
 	cfg, err := loadOrCreateConfig()
 	if err != nil {
 		log.Fatalf("Config error: %v", err)
@@ -369,9 +1502,13 @@ func main() {
 
 	switch os.Args[1] {
 	case "add":
-		runAdd(os.Args[2:], cfg)
+		runAdd(ctx, os.Args[2:], cfg)
+	case "sync":
+		runSync(ctx, os.Args[2:])
+	case "backup":
+		runBackup(ctx, os.Args[2:])
 	case "report":
-		runReport(os.Args[2:])
+		runReport(ctx, os.Args[2:])
 	case "help", "--help", "-h":
 		printHelp()
 	default:
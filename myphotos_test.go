@@ -2,14 +2,197 @@ package main
 
 import (
 	"archive/zip"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
+
+	"gocloud.dev/blob/memblob"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
+func TestScanLocalParallel(t *testing.T) {
+	db, dbPath := setupTestDB(t)
+	defer os.Remove(dbPath)
+	defer db.Close()
+
+	root, err := os.MkdirTemp("", "scan_local_parallel_*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	names := []string{"a.jpg", "b.jpg", "c.jpg"}
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(root, name), []byte("content-"+name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type scanResult struct {
+		count int64
+		err   error
+	}
+	runScan := func() scanResult {
+		done := make(chan scanResult, 1)
+		go func() {
+			count, err := scanLocalParallel(context.Background(), db, root, []string{".jpg"}, HashAlgoMD5, 2)
+			done <- scanResult{count, err}
+		}()
+		select {
+		case r := <-done:
+			return r
+		case <-time.After(10 * time.Second):
+			t.Fatal("scanLocalParallel did not return within 10s (deadlock?)")
+			return scanResult{}
+		}
+	}
+
+	r := runScan()
+	if r.err != nil {
+		t.Fatalf("scanLocalParallel failed: %v", r.err)
+	}
+	if r.count != int64(len(names)) {
+		t.Errorf("scanLocalParallel count = %d; want %d", r.count, len(names))
+	}
+
+	var rowCount int
+	if err := db.QueryRow("SELECT COUNT(*) FROM photos").Scan(&rowCount); err != nil {
+		t.Fatal(err)
+	}
+	if rowCount != len(names) {
+		t.Errorf("photos row count = %d; want %d", rowCount, len(names))
+	}
+
+	// Rescanning the same unchanged tree exercises the hash-cache hit path
+	// (getCachedHash against the scan's own tx) and must not deadlock
+	// either.
+	if r := runScan(); r.err != nil {
+		t.Fatalf("second scanLocalParallel failed: %v", r.err)
+	}
+}
+
+// --- Test Sync / Transport ---
+
+func TestSplitRemote(t *testing.T) {
+	tests := []struct {
+		remote   string
+		wantAddr string
+	}{
+		{"user@192.168.1.100", "192.168.1.100:22"},
+		{"user@192.168.1.100:2222", "192.168.1.100:2222"},
+		{"192.168.1.100", "192.168.1.100:22"},
+	}
+
+	for _, tt := range tests {
+		_, addr := splitRemote(tt.remote)
+		if addr != tt.wantAddr {
+			t.Errorf("splitRemote(%q) addr = %q; want %q", tt.remote, addr, tt.wantAddr)
+		}
+	}
+
+	// With no "user@" prefix, the current OS user should be used.
+	user, _ := splitRemote("192.168.1.100")
+	if user == "" {
+		t.Errorf("splitRemote(%q) user = %q; want the current OS user", "192.168.1.100", user)
+	}
+}
+
+func TestRemoteSyncPathPreservesDirectoryStructure(t *testing.T) {
+	got := remoteSyncPath("/backups", "/home/user/2023/vacation/img1.jpg")
+	want := "/backups/home/user/2023/vacation/img1.jpg"
+	if got != want {
+		t.Errorf("remoteSyncPath() = %q; want %q", got, want)
+	}
+
+	// Two files sharing a basename under different directories must not
+	// collide once mirrored under the remote root.
+	a := remoteSyncPath("/backups", "/home/user/cardA/DSC_0001.jpg")
+	b := remoteSyncPath("/backups", "/home/user/cardB/DSC_0001.jpg")
+	if a == b {
+		t.Errorf("remoteSyncPath collided for distinct local paths: %q", a)
+	}
+}
+
+// --- Test Bucket Scanning ---
+
+func TestIsBucketRemote(t *testing.T) {
+	tests := []struct {
+		remote   string
+		expected bool
+	}{
+		{"s3://my-bucket", true},
+		{"gs://my-bucket", true},
+		{"azblob://my-container", true},
+		{"user@192.168.1.100", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := isBucketRemote(tt.remote); got != tt.expected {
+			t.Errorf("isBucketRemote(%q) = %v; want %v", tt.remote, got, tt.expected)
+		}
+	}
+}
+
+func TestHashBucketObjectMatchesLocalAlgorithm(t *testing.T) {
+	ctx := context.Background()
+	bucket := memblob.OpenBucket(nil)
+	defer bucket.Close()
+
+	content := []byte("some photo bytes")
+	if err := bucket.WriteAll(ctx, "2023/img1.jpg", content, nil); err != nil {
+		t.Fatalf("Failed to seed bucket object: %v", err)
+	}
+
+	md5Sum := md5.Sum(content)
+	got, err := hashBucketObject(ctx, bucket, "2023/img1.jpg", HashAlgoMD5)
+	if err != nil {
+		t.Fatalf("hashBucketObject (md5) failed: %v", err)
+	}
+	if got != hex.EncodeToString(md5Sum[:]) {
+		t.Errorf("hashBucketObject (md5) = %q; want %q", got, hex.EncodeToString(md5Sum[:]))
+	}
+
+	// A bucket object's ListObject.MD5 is never valid for sha256 - callers
+	// must stream and hash it themselves with the configured algorithm.
+	sha256Sum := sha256.Sum256(content)
+	got, err = hashBucketObject(ctx, bucket, "2023/img1.jpg", HashAlgoSHA256)
+	if err != nil {
+		t.Fatalf("hashBucketObject (sha256) failed: %v", err)
+	}
+	if got != hex.EncodeToString(sha256Sum[:]) {
+		t.Errorf("hashBucketObject (sha256) = %q; want %q", got, hex.EncodeToString(sha256Sum[:]))
+	}
+}
+
+func TestDryTransporterUpload(t *testing.T) {
+	tempFile, err := os.CreateTemp("", "dry_transporter_*.jpg")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tempFile.Name())
+	if _, err := tempFile.WriteString("image data"); err != nil {
+		t.Fatal(err)
+	}
+	tempFile.Close()
+
+	var transporter Transporter = &DryTransporter{}
+	if err := transporter.Upload(tempFile.Name(), "/remote/img.jpg"); err != nil {
+		t.Errorf("DryTransporter.Upload failed: %v", err)
+	}
+
+	if err := transporter.Upload("/does/not/exist.jpg", "/remote/img.jpg"); err == nil {
+		t.Errorf("DryTransporter.Upload with missing local file: expected an error, got nil")
+	}
+}
+
 // --- Test Configuration & Extensions ---
 
 func TestIsExtensionAllowed(t *testing.T) {
@@ -46,7 +229,7 @@ func setupTestDB(t *testing.T) (*sql.DB, string) {
 	}
 	tempFile.Close() // Close it so sqlite can open it
 
-	db, err := initDB(tempFile.Name())
+	db, err := initDB(context.Background(), tempFile.Name())
 	if err != nil {
 		t.Fatalf("Failed to init DB: %v", err)
 	}
@@ -60,33 +243,34 @@ func TestDatabaseUpsertFlow(t *testing.T) {
 	defer db.Close()
 
 	// 1. Add a file found LOCALLY
+	hash := "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
 	filename := "img1.jpg"
 	size := int64(1024)
 	localPath := "/local/2023/vacation/img1.jpg"
-	if err := upsertLocal(db, filename, size, localPath); err != nil {
+	if err := upsertLocal(context.Background(), db, hash, filename, size, localPath); err != nil {
 		t.Fatalf("upsertLocal failed: %v", err)
 	}
 
 	// Verify state: Local=1, Remote=0
-	assertFileState(t, db, filename, size, true, false)
+	assertFileState(t, db, hash, true, false)
 
 	// 2. Add the SAME file found REMOTELY (This simulates a backup existing)
 	// This should update the row, not error out, and not overwrite on_local
 	remotePath := "/remote/backup/img1.jpg"
-	if err := upsertRemote(db, filename, size, remotePath); err != nil {
+	if err := upsertRemote(context.Background(), db, hash, filename, size, remotePath); err != nil {
 		t.Fatalf("upsertRemote failed: %v", err)
 	}
 
 	// Verify state: Local=1, Remote=1
-	assertFileState(t, db, filename, size, true, true)
+	assertFileState(t, db, hash, true, true)
 
 	// 3. Add a NEW file found REMOTELY only
-	if err := upsertRemote(db, "img2.jpg", 2048, "/remote/old/img2.jpg"); err != nil {
+	if err := upsertRemote(context.Background(), db, "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb", "img2.jpg", 2048, "/remote/old/img2.jpg"); err != nil {
 		t.Fatalf("upsertRemote new file failed: %v", err)
 	}
 
 	// Verify state: Local=0, Remote=1
-	assertFileState(t, db, "img2.jpg", 2048, false, true)
+	assertFileState(t, db, "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb", false, true)
 }
 
 func TestReportingLogic(t *testing.T) {
@@ -99,10 +283,11 @@ func TestReportingLogic(t *testing.T) {
 	// File B: Remote only (Maybe deleted locally, or archived)
 	// File C: Both (Safe)
 
-	upsertLocal(db, "fileA.jpg", 100, "/local/fileA.jpg")
-	upsertRemote(db, "fileB.jpg", 200, "/remote/fileB.jpg")
-	upsertLocal(db, "fileC.jpg", 300, "/local/fileC.jpg")
-	upsertRemote(db, "fileC.jpg", 300, "/remote/fileC.jpg")
+	ctx := context.Background()
+	upsertLocal(ctx, db, "hashA", "fileA.jpg", 100, "/local/fileA.jpg")
+	upsertRemote(ctx, db, "hashB", "fileB.jpg", 200, "/remote/fileB.jpg")
+	upsertLocal(ctx, db, "hashC", "fileC.jpg", 300, "/local/fileC.jpg")
+	upsertRemote(ctx, db, "hashC", "fileC.jpg", 300, "/remote/fileC.jpg")
 
 	// Query for "Missing from Remote" (The logic used in runReport)
 	rows, err := db.Query("SELECT local_path FROM photos WHERE local_path IS NOT NULL AND (remote_path IS NULL OR remote_path = '')")
@@ -127,17 +312,67 @@ func TestReportingLogic(t *testing.T) {
 	}
 }
 
+func TestWrongSizeReportExcludesSameSizeCorruption(t *testing.T) {
+	db, dbPath := setupTestDB(t)
+	defer os.Remove(dbPath)
+	defer db.Close()
+
+	// Same filename, same size, but different hash: this is -corrupt's
+	// scenario, not -wrong_size's, and must not be reported by both.
+	ctx := context.Background()
+	upsertLocal(ctx, db, "hashLocal", "fileA.jpg", 100, "/local/fileA.jpg")
+	upsertRemote(ctx, db, "hashRemote", "fileA.jpg", 100, "/remote/fileA.jpg")
+
+	// Same filename, genuinely different size: this is what -wrong_size
+	// should report.
+	upsertLocal(ctx, db, "hashB", "fileB.jpg", 100, "/local/fileB.jpg")
+	upsertRemote(ctx, db, "hashB2", "fileB.jpg", 200, "/remote/fileB.jpg")
+
+	// The query used by runReport's -wrong_size flag.
+	query := `
+		SELECT p1.local_path, p1.size, p2.remote_path, p2.size
+		FROM photos p1
+		JOIN photos p2 ON p1.filename = p2.filename
+		WHERE p1.local_path IS NOT NULL AND p1.local_path != ''
+		  AND (p1.remote_path IS NULL OR p1.remote_path = '')
+		  AND p2.remote_path IS NOT NULL AND p2.remote_path != ''
+		  AND p1.size != p2.size
+	`
+	rows, err := db.Query(query)
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	defer rows.Close()
+
+	var results []string
+	for rows.Next() {
+		var lPath, rPath string
+		var lSize, rSize int64
+		if err := rows.Scan(&lPath, &lSize, &rPath, &rSize); err != nil {
+			t.Fatalf("Scan failed: %v", err)
+		}
+		if lSize == rSize {
+			t.Errorf("-wrong_size reported a same-size pair: %s (%d) / %s (%d)", lPath, lSize, rPath, rSize)
+		}
+		results = append(results, lPath)
+	}
+
+	if len(results) != 1 || results[0] != "/local/fileB.jpg" {
+		t.Errorf("Expected only /local/fileB.jpg to be reported, got %v", results)
+	}
+}
+
 // --- Helper Functions ---
 
-func assertFileState(t *testing.T, db *sql.DB, filename string, size int64, expectLocal, expectRemote bool) {
+func assertFileState(t *testing.T, db *sql.DB, fileHash string, expectLocal, expectRemote bool) {
 	t.Helper()
 	var localPath, remotePath sql.NullString
-	
-	row := db.QueryRow("SELECT local_path, remote_path FROM photos WHERE filename = ? AND size = ?", filename, size)
+
+	row := db.QueryRow("SELECT local_path, remote_path FROM photos WHERE hash = ?", fileHash)
 	err := row.Scan(&localPath, &remotePath)
-	
+
 	if err == sql.ErrNoRows {
-		t.Fatalf("File %s not found in DB", filename)
+		t.Fatalf("Hash %s not found in DB", fileHash)
 	}
 	if err != nil {
 		t.Fatalf("Error scanning row: %v", err)
@@ -147,10 +382,113 @@ func assertFileState(t *testing.T, db *sql.DB, filename string, size int64, expe
 	onRemote := remotePath.Valid && remotePath.String != ""
 
 	if onLocal != expectLocal {
-		t.Errorf("File %s: expected on_local=%v, got %v", filename, expectLocal, onLocal)
+		t.Errorf("Hash %s: expected on_local=%v, got %v", fileHash, expectLocal, onLocal)
 	}
 	if onRemote != expectRemote {
-		t.Errorf("File %s: expected on_remote=%v, got %v", filename, expectRemote, onRemote)
+		t.Errorf("Hash %s: expected on_remote=%v, got %v", fileHash, expectRemote, onRemote)
+	}
+}
+
+// --- Test Backup Volumes ---
+
+func TestBackupVolumeName(t *testing.T) {
+	ts, err := time.Parse(backupTimeLayout, "2023-06-01-12-30-00")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := backupVolumeName(ts, 1), "myphotos-backup-2023-06-01-12-30-00.zip"; got != want {
+		t.Errorf("backupVolumeName(volume=1) = %q; want %q", got, want)
+	}
+	if got, want := backupVolumeName(ts, 2), "myphotos-backup-2023-06-01-12-30-00.2.zip"; got != want {
+		t.Errorf("backupVolumeName(volume=2) = %q; want %q", got, want)
+	}
+}
+
+func TestBackupFileTimestamp(t *testing.T) {
+	want, err := time.Parse(backupTimeLayout, "2023-06-01-12-30-00")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		wantOk  bool
+		wantVol bool
+	}{
+		{"myphotos-backup-2023-06-01-12-30-00.zip", true, false},
+		{"myphotos-backup-2023-06-01-12-30-00.2.zip", true, true},
+		{"not-a-backup.zip", false, false},
+		{"myphotos-backup-bogus-timestamp.zip", false, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := backupFileTimestamp(tt.name)
+		if ok != tt.wantOk {
+			t.Errorf("backupFileTimestamp(%q) ok = %v; want %v", tt.name, ok, tt.wantOk)
+			continue
+		}
+		if ok && !got.Equal(want) {
+			t.Errorf("backupFileTimestamp(%q) = %v; want %v", tt.name, got, want)
+		}
+		if ok {
+			m := backupFilenamePattern.FindStringSubmatch(tt.name)
+			if gotVol := m[2] != ""; gotVol != tt.wantVol {
+				t.Errorf("backupFilenamePattern(%q) numbered volume = %v; want %v", tt.name, gotVol, tt.wantVol)
+			}
+		}
+	}
+}
+
+func TestPruneBackups(t *testing.T) {
+	dir, err := os.MkdirTemp("", "prune_backups_*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	older, err := time.Parse(backupTimeLayout, "2023-01-01-00-00-00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newer, err := time.Parse(backupTimeLayout, "2023-06-01-00-00-00")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{
+		backupVolumeName(older, 1),
+		backupVolumeName(newer, 1),
+		backupVolumeName(newer, 2), // second volume of the same (kept) run
+		"unrelated.txt",
+	}
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := pruneBackups(dir, 1); err != nil {
+		t.Fatalf("pruneBackups failed: %v", err)
+	}
+
+	remaining, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remainingNames := make(map[string]bool)
+	for _, e := range remaining {
+		remainingNames[e.Name()] = true
+	}
+
+	if remainingNames[backupVolumeName(older, 1)] {
+		t.Errorf("expected older run's volume to be pruned, but it remains")
+	}
+	if !remainingNames[backupVolumeName(newer, 1)] || !remainingNames[backupVolumeName(newer, 2)] {
+		t.Errorf("expected both volumes of the kept run to remain, got %v", remainingNames)
+	}
+	if !remainingNames["unrelated.txt"] {
+		t.Errorf("expected unrelated.txt to be left alone, got %v", remainingNames)
 	}
 }
 
@@ -178,7 +516,7 @@ func TestZipMissing(t *testing.T) {
 	}
 
 	// Add to DB as a local file
-	if err := upsertLocal(db, "missing.jpg", int64(len(content)), fullPath); err != nil {
+	if err := upsertLocal(context.Background(), db, "cccccccccccccccccccccccccccccccc", "missing.jpg", int64(len(content)), fullPath); err != nil {
 		t.Fatal(err)
 	}
 